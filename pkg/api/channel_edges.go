@@ -0,0 +1,373 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrInvalidChannelEdge error indicates that a channel edge being added
+	// would leave the channel's upgrade graph without exactly one head.
+	// Packages that don't belong to the channel's application/arch are
+	// rejected with validatePackage's errors instead.
+	ErrInvalidChannelEdge = errors.New("nebraska: invalid channel edge")
+
+	// ErrChannelEdgeCycle error indicates that adding an edge would
+	// introduce a cycle in the channel's upgrade graph.
+	ErrChannelEdgeCycle = errors.New("nebraska: channel edge would introduce a cycle")
+
+	// ErrNoChannelHead error indicates that a channel's upgrade graph has no
+	// single node to resolve as the head.
+	ErrNoChannelHead = errors.New("nebraska: channel has no head package")
+
+	// ErrPackageNotInChannelGraph error indicates that a package doesn't
+	// appear in a channel's upgrade graph, so no next hop can be resolved
+	// for it.
+	ErrPackageNotInChannelGraph = errors.New("nebraska: package not in channel upgrade graph")
+)
+
+// ChannelEdge represents a directed "replaces" edge between two packages in
+// a channel's upgrade graph. Skips lists the ids of extra packages this edge
+// allows clients to jump over in a single hop, mirroring the replaces/skips model
+// used by operator-registry's graph loader.
+type ChannelEdge struct {
+	ID            string         `db:"id" json:"id"`
+	ChannelID     string         `db:"channel_id" json:"channel_id"`
+	FromPackageID string         `db:"from_package_id" json:"from_package_id"`
+	ToPackageID   string         `db:"to_package_id" json:"to_package_id"`
+	Skips         pq.StringArray `db:"skips" json:"skips"`
+	CreatedTs     time.Time      `db:"created_ts" json:"created_ts"`
+}
+
+// AddChannelEdge registers a new replaces edge between fromPackageID and
+// toPackageID in the channel's upgrade graph. It rejects edges that would
+// introduce a cycle or that would leave the graph with anything other than
+// exactly one head. After inserting the edge, AddChannelEdge sets the
+// channel's legacy PackageID pointer to the graph's computed head, so code
+// that still reads PackageID sees what GetChannelHead/GetNextChannelPackage
+// resolve. UpdateChannel and PatchChannel don't check the graph, so they can
+// still point PackageID at a different package.
+func (api *API) AddChannelEdge(channelID, fromPackageID, toPackageID string, skips []string) (*ChannelEdge, error) {
+	channel, err := api.GetChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := api.validatePackage(fromPackageID, channel); err != nil {
+		return nil, err
+	}
+	if _, err := api.validatePackage(toPackageID, channel); err != nil {
+		return nil, err
+	}
+
+	tx, err := api.dbR.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	// The channel row is locked before the no-cycle/single-head invariants
+	// are checked against the edges visible inside this transaction, so a
+	// concurrent AddChannelEdge on the same channel waits for this one to
+	// commit and can't validate against the same pre-insert snapshot and
+	// slip in a conflicting edge.
+	if _, err := tx.SQL("SELECT id FROM channel WHERE id = $1 FOR UPDATE", channelID).Exec(); err != nil {
+		return nil, err
+	}
+
+	var edges []*ChannelEdge
+	err = tx.
+		Select("*").
+		From("channel_edge").
+		Where("channel_id = $1", channelID).
+		OrderBy("created_ts ASC, id ASC").
+		QueryStructs(&edges)
+	if err != nil {
+		return nil, err
+	}
+
+	if edgeCreatesCycle(edges, fromPackageID, toPackageID) {
+		return nil, ErrChannelEdgeCycle
+	}
+
+	candidate := &ChannelEdge{FromPackageID: fromPackageID, ToPackageID: toPackageID}
+	allEdges := append(edges, candidate)
+	if countChannelHeads(allEdges) != 1 {
+		return nil, ErrInvalidChannelEdge
+	}
+
+	edge := &ChannelEdge{
+		ChannelID:     channelID,
+		FromPackageID: fromPackageID,
+		ToPackageID:   toPackageID,
+		Skips:         pq.StringArray(skips),
+	}
+
+	err = tx.
+		InsertInto("channel_edge").
+		Whitelist("channel_id", "from_package_id", "to_package_id", "skips").
+		Record(edge).
+		Returning("*").
+		QueryStruct(edge)
+	if err != nil {
+		return nil, err
+	}
+
+	headPackageID, err := channelHeadPackageID(allEdges)
+	if err != nil {
+		return nil, err
+	}
+
+	headChanged := headPackageID != channel.PackageID.String
+	if headChanged {
+		if _, err := tx.Update("channel").Set("package_id", headPackageID).Where("id = $1", channelID).Exec(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if headChanged {
+		if headPkg, err := api.GetPackage(headPackageID); err == nil {
+			_ = api.newChannelActivityEntry(activityChannelPackageUpdated, activityInfo, headPkg.Version, headPkg.ApplicationID, channelID)
+		}
+	}
+
+	return edge, nil
+}
+
+// RemoveChannelEdge removes the channel edge identified by the id provided
+// and, if the channel's graph still resolves to a single head afterwards,
+// updates its legacy PackageID pointer to match so it doesn't drift from
+// the graph (see AddChannelEdge).
+func (api *API) RemoveChannelEdge(edgeID string) error {
+	tx, err := api.dbR.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	var edge ChannelEdge
+	err = tx.
+		Select("*").
+		From("channel_edge").
+		Where("id = $1", edgeID).
+		QueryStruct(&edge)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNoRowsAffected
+		}
+		return err
+	}
+
+	if _, err := tx.DeleteFrom("channel_edge").Where("id = $1", edgeID).Exec(); err != nil {
+		return err
+	}
+
+	var remaining []*ChannelEdge
+	err = tx.
+		Select("*").
+		From("channel_edge").
+		Where("channel_id = $1", edge.ChannelID).
+		QueryStructs(&remaining)
+	if err != nil {
+		return err
+	}
+
+	// Only sync PackageID when the remaining graph still resolves to a
+	// single head; otherwise (no edges left, or a head became ambiguous)
+	// leave it untouched rather than guessing.
+	if headPackageID, err := channelHeadPackageID(remaining); err == nil {
+		if _, err := tx.Update("channel").Set("package_id", headPackageID).Where("id = $1", edge.ChannelID).Exec(); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetChannelHead computes and returns the package at the head of the
+// channel's upgrade graph, i.e. the node with no outgoing replaces edge. It
+// returns ErrArchivedChannel for an archived channel, since an archived
+// channel is not a valid target to resolve updates for new instances
+// against.
+func (api *API) GetChannelHead(channelID string) (*Package, error) {
+	channel, err := api.GetChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if channel.IsArchived() {
+		return nil, ErrArchivedChannel
+	}
+
+	edges, err := api.getChannelEdges(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	headPackageID, err := channelHeadPackageID(edges)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.GetPackage(headPackageID)
+}
+
+// GetNextChannelPackage walks the channel's upgrade graph from fromPackageID
+// towards the head and returns the next package on the path, honoring any
+// skips set that lets fromPackageID jump directly to a later package in a
+// single hop. It doesn't necessarily return the head: callers are expected
+// to call it repeatedly as the client upgrades one hop at a time. It
+// returns ErrArchivedChannel for an archived channel, since it's not a
+// valid source of updates for new instances.
+//
+// A skips match takes priority over fromPackageID's own outgoing edge: a
+// broken intermediate version still has the outgoing edge that was wired
+// for it when it was the head, and skips exists precisely to route clients
+// sitting on it around that edge instead of following it.
+//
+// A branching graph (a node with more than one valid outgoing edge, e.g.
+// A->B->D and A->C->D built up incrementally) is accepted by the
+// single-head/no-cycle invariants but isn't disambiguated here beyond
+// getChannelEdges' deterministic ordering: the edge added to the channel
+// first wins. Branching upgrade graphs are otherwise unsupported.
+func (api *API) GetNextChannelPackage(channelID, fromPackageID string) (*Package, error) {
+	channel, err := api.GetChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if channel.IsArchived() {
+		return nil, ErrArchivedChannel
+	}
+
+	edges, err := api.getChannelEdges(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, edge := range edges {
+		for _, skipped := range edge.Skips {
+			if skipped == fromPackageID {
+				return api.GetPackage(edge.ToPackageID)
+			}
+		}
+	}
+
+	for _, edge := range edges {
+		if edge.FromPackageID == fromPackageID {
+			return api.GetPackage(edge.ToPackageID)
+		}
+	}
+
+	headPackageID, err := channelHeadPackageID(edges)
+	if err != nil {
+		return nil, err
+	}
+	if headPackageID == fromPackageID {
+		return api.GetPackage(fromPackageID)
+	}
+
+	return nil, ErrPackageNotInChannelGraph
+}
+
+// getChannelEdges returns all the edges belonging to the channel's upgrade
+// graph, ordered by creation so that callers resolving a branching node
+// (more than one valid outgoing edge) deterministically pick the edge that
+// was added to the channel first.
+func (api *API) getChannelEdges(channelID string) ([]*ChannelEdge, error) {
+	var edges []*ChannelEdge
+
+	err := api.dbR.
+		Select("*").
+		From("channel_edge").
+		Where("channel_id = $1", channelID).
+		OrderBy("created_ts ASC, id ASC").
+		QueryStructs(&edges)
+
+	return edges, err
+}
+
+// channelHeadPackageID returns the package id of the only node in edges
+// without an outgoing edge, failing if there isn't exactly one.
+func channelHeadPackageID(edges []*ChannelEdge) (string, error) {
+	nodes := make(map[string]bool)
+	hasOutgoing := make(map[string]bool)
+	for _, edge := range edges {
+		nodes[edge.FromPackageID] = true
+		nodes[edge.ToPackageID] = true
+		hasOutgoing[edge.FromPackageID] = true
+	}
+
+	var head string
+	heads := 0
+	for node := range nodes {
+		if !hasOutgoing[node] {
+			head = node
+			heads++
+		}
+	}
+
+	if heads != 1 {
+		return "", ErrNoChannelHead
+	}
+
+	return head, nil
+}
+
+// countChannelHeads returns how many nodes in edges have no outgoing edge.
+// It's used to validate the single-head invariant before an edge is
+// persisted.
+func countChannelHeads(edges []*ChannelEdge) int {
+	nodes := make(map[string]bool)
+	hasOutgoing := make(map[string]bool)
+	for _, edge := range edges {
+		nodes[edge.FromPackageID] = true
+		nodes[edge.ToPackageID] = true
+		hasOutgoing[edge.FromPackageID] = true
+	}
+
+	heads := 0
+	for node := range nodes {
+		if !hasOutgoing[node] {
+			heads++
+		}
+	}
+
+	return heads
+}
+
+// edgeCreatesCycle reports whether adding an edge from -> to would
+// introduce a cycle in the graph described by edges, i.e. whether to can
+// already reach from.
+func edgeCreatesCycle(edges []*ChannelEdge, from, to string) bool {
+	if from == to {
+		return true
+	}
+
+	adjacency := make(map[string][]string)
+	for _, edge := range edges {
+		adjacency[edge.FromPackageID] = append(adjacency[edge.FromPackageID], edge.ToPackageID)
+	}
+
+	visited := make(map[string]bool)
+	queue := []string{to}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node == from {
+			return true
+		}
+		if visited[node] {
+			continue
+		}
+		visited[node] = true
+		queue = append(queue, adjacency[node]...)
+	}
+
+	return false
+}
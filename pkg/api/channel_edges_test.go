@@ -0,0 +1,142 @@
+package api
+
+import "testing"
+
+func edge(from, to string) *ChannelEdge {
+	return &ChannelEdge{FromPackageID: from, ToPackageID: to}
+}
+
+func TestChannelHeadPackageID(t *testing.T) {
+	tests := []struct {
+		name    string
+		edges   []*ChannelEdge
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "single edge",
+			edges: []*ChannelEdge{edge("a", "b")},
+			want:  "b",
+		},
+		{
+			name:  "chain",
+			edges: []*ChannelEdge{edge("a", "b"), edge("b", "c")},
+			want:  "c",
+		},
+		{
+			name:    "no edges",
+			edges:   nil,
+			wantErr: true,
+		},
+		{
+			name: "diamond still has a single head",
+			// a->b->d and a->c->d: b and c both have outgoing edges, only d doesn't.
+			edges: []*ChannelEdge{edge("a", "b"), edge("a", "c"), edge("b", "d"), edge("c", "d")},
+			want:  "d",
+		},
+		{
+			name:    "two disconnected chains have two heads",
+			edges:   []*ChannelEdge{edge("a", "b"), edge("c", "d")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := channelHeadPackageID(tt.edges)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got head %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got head %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountChannelHeads(t *testing.T) {
+	tests := []struct {
+		name  string
+		edges []*ChannelEdge
+		want  int
+	}{
+		{name: "single edge", edges: []*ChannelEdge{edge("a", "b")}, want: 1},
+		{name: "no edges", edges: nil, want: 0},
+		{
+			name:  "two disconnected chains",
+			edges: []*ChannelEdge{edge("a", "b"), edge("c", "d")},
+			want:  2,
+		},
+		{
+			name:  "diamond collapses back to one head",
+			edges: []*ChannelEdge{edge("a", "b"), edge("a", "c"), edge("b", "d"), edge("c", "d")},
+			want:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countChannelHeads(tt.edges); got != tt.want {
+				t.Fatalf("got %d heads, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEdgeCreatesCycle(t *testing.T) {
+	tests := []struct {
+		name       string
+		edges      []*ChannelEdge
+		from, to   string
+		wantResult bool
+	}{
+		{
+			name:       "self edge is always a cycle",
+			from:       "a",
+			to:         "a",
+			wantResult: true,
+		},
+		{
+			name:       "extending a chain forward is not a cycle",
+			edges:      []*ChannelEdge{edge("a", "b")},
+			from:       "b",
+			to:         "c",
+			wantResult: false,
+		},
+		{
+			name:       "pointing back into the existing chain is a cycle",
+			edges:      []*ChannelEdge{edge("a", "b"), edge("b", "c")},
+			from:       "c",
+			to:         "a",
+			wantResult: true,
+		},
+		{
+			name:       "branching off an existing node is not a cycle",
+			edges:      []*ChannelEdge{edge("a", "b")},
+			from:       "a",
+			to:         "c",
+			wantResult: false,
+		},
+		{
+			name:       "disconnected nodes never cycle",
+			edges:      []*ChannelEdge{edge("a", "b")},
+			from:       "c",
+			to:         "d",
+			wantResult: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := edgeCreatesCycle(tt.edges, tt.from, tt.to); got != tt.wantResult {
+				t.Fatalf("got %v, want %v", got, tt.wantResult)
+			}
+		})
+	}
+}
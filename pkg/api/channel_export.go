@@ -0,0 +1,242 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"gopkg.in/mgutz/dat.v1"
+)
+
+var (
+	// ErrChannelExists error indicates that an import found a channel
+	// already present in the target application and replace mode wasn't
+	// requested.
+	ErrChannelExists = errors.New("nebraska: channel already exists")
+
+	// ErrPackageNotFound error indicates that an imported channel
+	// references a package version/arch that doesn't exist in the target
+	// application.
+	ErrPackageNotFound = errors.New("nebraska: referenced package not found")
+)
+
+// ChannelEdgeExport is the portable representation of a ChannelEdge. Like
+// ChannelExport, its packages are identified by version rather than by
+// internal id so the edge can be resolved against whatever ids the target
+// instance assigned its packages.
+type ChannelEdgeExport struct {
+	FromPackageVersion string   `json:"from_package_version"`
+	ToPackageVersion   string   `json:"to_package_version"`
+	SkipVersions       []string `json:"skip_versions,omitempty"`
+}
+
+// ChannelExport is the portable representation of a channel used to move
+// channel configurations between Nebraska instances. The referenced package
+// is identified by version and arch rather than by its internal id, as ids
+// aren't stable across instances. Edges carries the channel's upgrade graph,
+// if any, so graph-based channels (chunk0-1) survive a round trip too.
+type ChannelExport struct {
+	Name           string              `json:"name"`
+	Color          string              `json:"color"`
+	Arch           Arch                `json:"arch"`
+	PackageVersion string              `json:"package_version,omitempty"`
+	Edges          []ChannelEdgeExport `json:"edges,omitempty"`
+}
+
+// ExportChannels returns a portable representation of all the non-archived
+// channels belonging to the application provided, including each channel's
+// upgrade graph edges.
+func (api *API) ExportChannels(appID string) ([]ChannelExport, error) {
+	var channels []*Channel
+	err := api.channelsQuery(false).
+		Where("application_id = $1", appID).
+		QueryStructs(&channels)
+	if err != nil {
+		return nil, err
+	}
+
+	exports := make([]ChannelExport, 0, len(channels))
+	for _, channel := range channels {
+		export := ChannelExport{
+			Name:  channel.Name,
+			Color: channel.Color,
+			Arch:  channel.Arch,
+		}
+		if channel.Package != nil {
+			export.PackageVersion = channel.Package.Version
+		}
+
+		edges, err := api.getChannelEdges(channel.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, edge := range edges {
+			edgeExport, err := api.exportChannelEdge(edge)
+			if err != nil {
+				return nil, err
+			}
+			export.Edges = append(export.Edges, edgeExport)
+		}
+
+		exports = append(exports, export)
+	}
+
+	return exports, nil
+}
+
+// exportChannelEdge resolves a ChannelEdge's package ids to the portable,
+// version-based representation.
+func (api *API) exportChannelEdge(edge *ChannelEdge) (ChannelEdgeExport, error) {
+	fromPkg, err := api.GetPackage(edge.FromPackageID)
+	if err != nil {
+		return ChannelEdgeExport{}, err
+	}
+	toPkg, err := api.GetPackage(edge.ToPackageID)
+	if err != nil {
+		return ChannelEdgeExport{}, err
+	}
+
+	skipVersions := make([]string, 0, len(edge.Skips))
+	for _, skipPackageID := range edge.Skips {
+		skipPkg, err := api.GetPackage(skipPackageID)
+		if err != nil {
+			return ChannelEdgeExport{}, err
+		}
+		skipVersions = append(skipVersions, skipPkg.Version)
+	}
+
+	return ChannelEdgeExport{
+		FromPackageVersion: fromPkg.Version,
+		ToPackageVersion:   toPkg.Version,
+		SkipVersions:       skipVersions,
+	}, nil
+}
+
+// ImportChannels creates the channels described by entries in the
+// application identified by appID, resolving each entry's package reference
+// (and, for graph-based channels, its edges' package references) against
+// the target application's own packages. If replace is true, existing
+// non-archived channels sharing an entry's (application_id, name, arch) are
+// archived (soft-deleted) before the new ones are inserted; otherwise such
+// conflicts abort the whole import. Unresolved package references also
+// abort the import. Everything runs inside a single transaction.
+func (api *API) ImportChannels(appID string, entries []ChannelExport, replace bool) error {
+	tx, err := api.dbR.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	for _, entry := range entries {
+		var existing Channel
+		err := tx.
+			Select("id").
+			From("channel").
+			Where("application_id = $1 and name = $2 and arch = $3", appID, entry.Name, entry.Arch).
+			Where("deleted_ts is null").
+			QueryStruct(&existing)
+
+		switch err {
+		case nil:
+			if !replace {
+				return fmt.Errorf("%w: %s/%s", ErrChannelExists, entry.Name, entry.Arch)
+			}
+			// Archive, don't hard-delete: chunk0-2's soft-delete keeps the
+			// audit trail (activity entries, instance-status references)
+			// resolvable, and a raw DeleteFrom here would destroy it.
+			if _, err := tx.Update("channel").Set("deleted_ts", dat.NOW).Where("id = $1", existing.ID).Exec(); err != nil {
+				return err
+			}
+		case sql.ErrNoRows:
+			// no conflict (or the only match is already archived), proceed
+			// with the insert below.
+		default:
+			return err
+		}
+
+		channel := &Channel{
+			Name:          entry.Name,
+			Color:         entry.Color,
+			ApplicationID: appID,
+			Arch:          entry.Arch,
+		}
+
+		if entry.PackageVersion != "" {
+			packageID, err := resolvePackageIDByVersion(tx, appID, entry.PackageVersion, entry.Arch)
+			if err != nil {
+				return err
+			}
+			channel.PackageID.String = packageID
+			channel.PackageID.Valid = true
+		}
+
+		err = tx.
+			InsertInto("channel").
+			Whitelist("name", "color", "application_id", "package_id", "arch").
+			Record(channel).
+			Returning("*").
+			QueryStruct(channel)
+		if err != nil {
+			return err
+		}
+
+		for _, edgeEntry := range entry.Edges {
+			if err := importChannelEdge(tx, channel, edgeEntry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// importChannelEdge resolves edgeEntry's package versions against channel's
+// application/arch and inserts the corresponding channel_edge row.
+func importChannelEdge(tx *dat.Tx, channel *Channel, edgeEntry ChannelEdgeExport) error {
+	fromPackageID, err := resolvePackageIDByVersion(tx, channel.ApplicationID, edgeEntry.FromPackageVersion, channel.Arch)
+	if err != nil {
+		return err
+	}
+	toPackageID, err := resolvePackageIDByVersion(tx, channel.ApplicationID, edgeEntry.ToPackageVersion, channel.Arch)
+	if err != nil {
+		return err
+	}
+
+	skips := make([]string, 0, len(edgeEntry.SkipVersions))
+	for _, version := range edgeEntry.SkipVersions {
+		skipPackageID, err := resolvePackageIDByVersion(tx, channel.ApplicationID, version, channel.Arch)
+		if err != nil {
+			return err
+		}
+		skips = append(skips, skipPackageID)
+	}
+
+	edge := &ChannelEdge{
+		ChannelID:     channel.ID,
+		FromPackageID: fromPackageID,
+		ToPackageID:   toPackageID,
+		Skips:         skips,
+	}
+
+	return tx.
+		InsertInto("channel_edge").
+		Whitelist("channel_id", "from_package_id", "to_package_id", "skips").
+		Record(edge).
+		Exec()
+}
+
+// resolvePackageIDByVersion looks up a package's internal id by its portable
+// (version, arch) identity within an application.
+func resolvePackageIDByVersion(tx *dat.Tx, appID, version string, arch Arch) (string, error) {
+	var pkg Package
+	err := tx.
+		Select("id").
+		From("package").
+		Where("application_id = $1 and version = $2 and arch = $3", appID, version, arch).
+		QueryStruct(&pkg)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s/%s", ErrPackageNotFound, version, arch)
+	}
+
+	return pkg.ID, nil
+}
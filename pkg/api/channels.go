@@ -15,6 +15,10 @@ var (
 	// ErrBlacklistedChannel error indicates an attempt of creating/updating a
 	// channel using a package that has blacklisted the channel.
 	ErrBlacklistedChannel = errors.New("nebraska: blacklisted channel")
+
+	// ErrArchivedChannel error indicates an attempt to assign a package to,
+	// or resolve updates from, a channel that has been soft-deleted.
+	ErrArchivedChannel = errors.New("nebraska: channel is archived")
 )
 
 // Channel represents a Nebraska application's channel.
@@ -27,6 +31,7 @@ type Channel struct {
 	PackageID     dat.NullString `db:"package_id" json:"package_id"`
 	Package       *Package       `db:"package" json:"package"`
 	Arch          Arch           `db:"arch" json:"arch"`
+	DeletedTs     dat.NullTime   `db:"deleted_ts" json:"deleted_ts,omitempty"`
 }
 
 // AddChannel registers the provided channel.
@@ -35,7 +40,7 @@ func (api *API) AddChannel(channel *Channel) (*Channel, error) {
 		return nil, ErrInvalidArch
 	}
 	if channel.PackageID.String != "" {
-		if _, err := api.validatePackage(channel.PackageID.String, channel.ID, channel.ApplicationID, channel.Arch); err != nil {
+		if _, err := api.validatePackage(channel.PackageID.String, channel); err != nil {
 			return nil, err
 		}
 	}
@@ -60,7 +65,7 @@ func (api *API) UpdateChannel(channel *Channel) error {
 
 	var pkg *Package
 	if channel.PackageID.String != "" {
-		if pkg, err = api.validatePackage(channel.PackageID.String, channel.ID, channelBeforeUpdate.ApplicationID, channelBeforeUpdate.Arch); err != nil {
+		if pkg, err = api.validatePackage(channel.PackageID.String, channelBeforeUpdate); err != nil {
 			return err
 		}
 	}
@@ -86,25 +91,136 @@ func (api *API) UpdateChannel(channel *Channel) error {
 	return nil
 }
 
-// DeleteChannel removes the channel identified by the id provided.
+// ChannelPatch carries the subset of a channel's fields a caller wants to
+// change. A nil field is left alone; PackageID additionally distinguishes a
+// nil pointer ("leave alone") from a pointer to an empty dat.NullString
+// ("unset the channel's package").
+type ChannelPatch struct {
+	Name      *string         `json:"name"`
+	Color     *string         `json:"color"`
+	PackageID *dat.NullString `json:"package_id"`
+}
+
+// PatchChannel updates only the fields set in patch on the channel
+// identified by channelID, leaving the rest untouched.
+//
+// NOTE: this is the api-layer half of the request only. There is no
+// pkg/server (or other router) package in this tree yet to add the
+// PATCH /channels/{id} handler the request calls for, so that route isn't
+// wired up and this capability isn't reachable over HTTP. Flagging this
+// request as partially complete rather than done.
+func (api *API) PatchChannel(channelID string, patch *ChannelPatch) error {
+	channelBeforeUpdate, err := api.GetChannel(channelID)
+	if err != nil {
+		return err
+	}
+
+	columns := make([]string, 0, 3)
+	update := api.dbR.Update("channel")
+
+	if patch.Name != nil {
+		update = update.Set("name", *patch.Name)
+		columns = append(columns, "name")
+	}
+	if patch.Color != nil {
+		update = update.Set("color", *patch.Color)
+		columns = append(columns, "color")
+	}
+
+	var pkg *Package
+	if patch.PackageID != nil {
+		if patch.PackageID.String != "" {
+			if pkg, err = api.validatePackage(patch.PackageID.String, channelBeforeUpdate); err != nil {
+				return err
+			}
+			update = update.Set("package_id", patch.PackageID.String)
+		} else {
+			update = update.Set("package_id", nil)
+		}
+		columns = append(columns, "package_id")
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
+
+	result, err := update.
+		Where("id = $1", channelID).
+		Exec()
+
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrNoRowsAffected
+	}
+
+	if patch.PackageID != nil && channelBeforeUpdate.PackageID.String != patch.PackageID.String {
+		switch {
+		case pkg != nil:
+			// Setting (or changing) the package: log the newly assigned version.
+			_ = api.newChannelActivityEntry(activityChannelPackageUpdated, activityInfo, pkg.Version, pkg.ApplicationID, channelID)
+		case channelBeforeUpdate.Package != nil:
+			// Unsetting the package: log the version that's being cleared.
+			_ = api.newChannelActivityEntry(activityChannelPackageUpdated, activityInfo, channelBeforeUpdate.Package.Version, channelBeforeUpdate.ApplicationID, channelID)
+		}
+	}
+
+	return nil
+}
+
+// DeleteChannel archives the channel identified by the id provided by
+// setting its deleted_ts, instead of removing the row. This keeps historical
+// activity entries and instance-status references resolvable while
+// excluding the channel from channelsQuery and new group assignments.
 func (api *API) DeleteChannel(channelID string) error {
 	result, err := api.dbR.
-		DeleteFrom("channel").
+		Update("channel").
+		Set("deleted_ts", dat.NOW).
 		Where("id = $1", channelID).
+		Where("deleted_ts is null").
 		Exec()
 
-	if err == nil && result.RowsAffected == 0 {
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
 		return ErrNoRowsAffected
 	}
 
-	return err
+	return nil
 }
 
-// GetChannel returns the channel identified by the id provided.
+// RestoreChannel un-archives a previously soft-deleted channel, making it
+// eligible again for new group assignments.
+func (api *API) RestoreChannel(channelID string) error {
+	result, err := api.dbR.
+		Update("channel").
+		Set("deleted_ts", nil).
+		Where("id = $1", channelID).
+		Where("deleted_ts is not null").
+		Exec()
+
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrNoRowsAffected
+	}
+
+	return nil
+}
+
+// GetChannel returns the channel identified by the id provided, including
+// archived (soft-deleted) channels, so callers resolving a channel's
+// name/color for history rendering still succeed after it's been deleted.
 func (api *API) GetChannel(channelID string) (*Channel, error) {
 	var channel Channel
 
-	err := api.channelsQuery().
+	err := api.channelsQuery(true).
 		Where("id = $1", channelID).
 		QueryStruct(&channel)
 
@@ -118,42 +234,108 @@ func (api *API) GetChannel(channelID string) (*Channel, error) {
 func (api *API) getSpecificChannels(channelID ...string) ([]*Channel, error) {
 	var channels []*Channel
 
-	err := api.channelsQuery().
+	err := api.channelsQuery(true).
 		Where("id in $1", channelID).
 		QueryStructs(&channels)
 
 	return channels, err
 }
 
-// GetChannels returns all channels associated to the application provided.
+// GetChannels returns all non-archived channels associated to the
+// application provided.
 func (api *API) GetChannels(appID string, page, perPage uint64) ([]*Channel, error) {
 	page, perPage = validatePaginationParams(page, perPage)
 
 	var channels []*Channel
 
-	err := api.channelsQuery().
+	err := api.channelsQuery(false).
+		Where("application_id = $1", appID).
+		Paginate(page, perPage).
+		QueryStructs(&channels)
+
+	return channels, err
+}
+
+// GetDeletedChannels returns the archived (soft-deleted) channels belonging
+// to the application provided.
+func (api *API) GetDeletedChannels(appID string, page, perPage uint64) ([]*Channel, error) {
+	page, perPage = validatePaginationParams(page, perPage)
+
+	var channels []*Channel
+
+	err := api.channelsQuery(true).
 		Where("application_id = $1", appID).
+		Where("channel.deleted_ts is not null").
 		Paginate(page, perPage).
 		QueryStructs(&channels)
 
 	return channels, err
 }
 
+// ChannelFilter holds the optional criteria SearchChannels narrows its
+// results by. A nil/empty field means "don't filter on this".
+type ChannelFilter struct {
+	Names      []string
+	Arch       *Arch
+	PackageID  *string
+	HasPackage *bool
+	NamePrefix *string
+}
+
+// SearchChannels returns the non-archived channels across all applications
+// matching the criteria in filter, e.g. every amd64 channel whose name
+// starts with "beta-", or every channel currently pointing at a given
+// package.
+func (api *API) SearchChannels(filter ChannelFilter) ([]*Channel, error) {
+	query := api.channelsQuery(false)
+
+	if len(filter.Names) > 0 {
+		query = query.Where("channel.name in $1", filter.Names)
+	}
+	if filter.Arch != nil {
+		query = query.Where("channel.arch = $1", *filter.Arch)
+	}
+	if filter.PackageID != nil {
+		query = query.Where("channel.package_id = $1", *filter.PackageID)
+	}
+	if filter.HasPackage != nil {
+		if *filter.HasPackage {
+			query = query.Where("channel.package_id is not null")
+		} else {
+			query = query.Where("channel.package_id is null")
+		}
+	}
+	if filter.NamePrefix != nil {
+		query = query.Where("channel.name like $1", *filter.NamePrefix+"%")
+	}
+
+	var channels []*Channel
+	err := query.QueryStructs(&channels)
+
+	return channels, err
+}
+
 // validatePackage checks if a package belongs to the application provided and
-// that the channel is not in the package's channels blacklist. It returns the
-// package if everything is ok.
-func (api *API) validatePackage(packageID, channelID, appID string, channelArch Arch) (*Package, error) {
+// that the channel is not in the package's channels blacklist, and that the
+// channel itself isn't archived (archived channels are invalid targets for
+// new package assignments, though they remain resolvable for rendering
+// history elsewhere). It returns the package if everything is ok.
+func (api *API) validatePackage(packageID string, channel *Channel) (*Package, error) {
+	if channel.IsArchived() {
+		return nil, ErrArchivedChannel
+	}
+
 	pkg, err := api.GetPackage(packageID)
 	if err == nil {
-		if pkg.ApplicationID != appID {
+		if pkg.ApplicationID != channel.ApplicationID {
 			return nil, ErrInvalidPackage
 		}
-		if pkg.Arch != channelArch {
+		if pkg.Arch != channel.Arch {
 			return nil, ErrArchMismatch
 		}
 
 		for _, blacklistedChannelID := range pkg.ChannelsBlacklist {
-			if channelID == blacklistedChannelID {
+			if channel.ID == blacklistedChannelID {
 				return nil, ErrBlacklistedChannel
 			}
 		}
@@ -162,14 +344,35 @@ func (api *API) validatePackage(packageID, channelID, appID string, channelArch
 	return pkg, err
 }
 
-// channelsQuery returns a SelectDocBuilder prepared to return all channels.
-// This query is meant to be extended later in the methods using it to filter
-// by a specific channel id, all channels that belong to a given application,
+// channelsQuery returns a SelectDocBuilder prepared to return all channels,
+// excluding archived (soft-deleted) ones unless includeDeleted is true. This
+// query is meant to be extended later in the methods using it to filter by a
+// specific channel id, all channels that belong to a given application,
 // specify how to query the rows or their destination.
-func (api *API) channelsQuery() *dat.SelectDocBuilder {
-	return api.dbR.
+func (api *API) channelsQuery(includeDeleted bool) *dat.SelectDocBuilder {
+	query := api.dbR.
 		SelectDoc("*").
 		One("package", api.packagesQuery().Where("package.id = channel.package_id")).
 		From("channel").
 		OrderBy("name ASC")
+
+	if !includeDeleted {
+		query = query.Where("channel.deleted_ts is null")
+	}
+
+	return query
+}
+
+// IsArchived reports whether the channel has been soft-deleted. An archived
+// channel can't accept new package assignments (validatePackage rejects
+// them with ErrArchivedChannel) and can't serve updates to new instances
+// (GetChannelHead and GetNextChannelPackage do the same), while its
+// name/color remain resolvable via GetChannel for rendering history.
+//
+// NOTE: this package has no groups.go, so the instance-registration-time
+// check ("can a new instance even be assigned to this channel's group")
+// isn't implemented here; only the update-resolution and package-assignment
+// paths above are. That half of the request is still open, not done.
+func (c *Channel) IsArchived() bool {
+	return c.DeletedTs.Valid
 }